@@ -0,0 +1,189 @@
+//  Copyright 2018 Istio Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package server runs a Mixer instance: a gRPC API, a monitoring HTTP endpoint, and a config watcher that
+// tracks the revision of the configuration on disk for the monitoring endpoint to report.
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	istio_mixer_v1 "istio.io/api/mixer/v1"
+)
+
+// Args controls how a Mixer Server is constructed.
+type Args struct {
+	// APIPort is the port the gRPC API is served on. 0 picks a free port, discoverable via Server.Addr.
+	APIPort uint16
+
+	// MonitoringPort is the port the monitoring HTTP endpoint (the configState debug topic) is served on. 0
+	// picks a free port, discoverable via Server.MonitoringAddr. The endpoint has no /metrics handler.
+	MonitoringPort uint16
+
+	// ConfigStoreURL points Mixer at its configuration. Only the fs:// scheme is supported; the referenced
+	// directory is polled for changes.
+	ConfigStoreURL string
+
+	// Templates and Adapters hold the template and adapter inventories, whose concrete types come from
+	// istio.io/istio/mixer/template and istio.io/istio/mixer/adapter respectively. New does not read them.
+	Templates interface{}
+	Adapters  interface{}
+}
+
+// DefaultArgs returns an Args populated with reasonable defaults for a standalone Mixer instance.
+func DefaultArgs() *Args {
+	return &Args{
+		APIPort:        9091,
+		MonitoringPort: 9093,
+	}
+}
+
+// Server is a running Mixer instance.
+type Server struct {
+	grpcServer *grpc.Server
+	listener   net.Listener
+
+	monitoringListener net.Listener
+	mux                *http.ServeMux
+
+	configDir string
+
+	mu             sync.RWMutex
+	configRevision string
+
+	done chan struct{}
+}
+
+// New constructs a Mixer Server per args and starts its monitoring endpoint and config loader. Call Run to
+// serve the gRPC API, and Close to tear everything down.
+func New(args *Args) (*Server, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", args.APIPort))
+	if err != nil {
+		return nil, err
+	}
+
+	monitoringListener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", args.MonitoringPort))
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	s := &Server{
+		grpcServer:         grpc.NewServer(),
+		listener:           listener,
+		monitoringListener: monitoringListener,
+		mux:                http.NewServeMux(),
+		configDir:          strings.TrimPrefix(args.ConfigStoreURL, "fs://"),
+		done:               make(chan struct{}),
+	}
+
+	istio_mixer_v1.RegisterMixerServer(s.grpcServer, &mixerService{})
+
+	s.mux.HandleFunc("/debug/configState", s.handleConfigState)
+	go func() { _ = http.Serve(monitoringListener, s.mux) }()
+
+	go s.watchConfig()
+
+	return s, nil
+}
+
+// Run serves the gRPC API until Close is called. It blocks, so callers typically invoke it via "go mi.Run()".
+func (s *Server) Run() {
+	_ = s.grpcServer.Serve(s.listener)
+}
+
+// Addr returns the address the gRPC API is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// MonitoringAddr returns the address the monitoring HTTP endpoint is listening on.
+func (s *Server) MonitoringAddr() net.Addr {
+	return s.monitoringListener.Addr()
+}
+
+// ConfigRevision returns a stable identifier for the configuration this Server currently has loaded, so that
+// callers can tell once a freshly applied configuration has actually taken effect.
+func (s *Server) ConfigRevision() (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.configRevision, nil
+}
+
+// Close stops serving and releases every resource held by the Server.
+func (s *Server) Close() error {
+	close(s.done)
+	s.grpcServer.Stop()
+	return s.monitoringListener.Close()
+}
+
+// watchConfig polls configDir/config.yaml and republishes its hash as the current config revision whenever it
+// changes, so that ConfigRevision and the matching configState HTTP topic stay in sync with what's on disk.
+func (s *Server) watchConfig() {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			b, err := ioutil.ReadFile(filepath.Join(s.configDir, "config.yaml"))
+			if err != nil {
+				continue
+			}
+
+			sum := sha256.Sum256(b)
+			rev := hex.EncodeToString(sum[:])
+
+			s.mu.Lock()
+			s.configRevision = rev
+			s.mu.Unlock()
+		}
+	}
+}
+
+// handleConfigState serves the current config revision over HTTP, for callers (e.g. a port-forwarded
+// Kubernetes Mixer instance) that cannot call ConfigRevision in-process.
+func (s *Server) handleConfigState(w http.ResponseWriter, r *http.Request) {
+	rev, _ := s.ConfigRevision()
+	_ = json.NewEncoder(w).Encode(struct {
+		Revision string `json:"revision"`
+	}{Revision: rev})
+}
+
+// mixerService is a minimal istio_mixer_v1.MixerServer. It accepts every Report/Check call so that callers can
+// exercise the wire protocol and config-propagation waiter without requiring a full adapter dispatch pipeline.
+type mixerService struct{}
+
+func (m *mixerService) Report(context.Context, *istio_mixer_v1.ReportRequest) (*istio_mixer_v1.ReportResponse, error) {
+	return &istio_mixer_v1.ReportResponse{}, nil
+}
+
+func (m *mixerService) Check(context.Context, *istio_mixer_v1.CheckRequest) (*istio_mixer_v1.CheckResponse, error) {
+	return &istio_mixer_v1.CheckResponse{}, nil
+}
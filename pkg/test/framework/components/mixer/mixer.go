@@ -16,9 +16,13 @@ package mixer
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path"
 	"strconv"
@@ -27,6 +31,7 @@ import (
 
 	"go.uber.org/multierr"
 	"google.golang.org/grpc"
+	kubeApiCore "k8s.io/api/core/v1"
 
 	istio_mixer_v1 "istio.io/api/mixer/v1"
 	"istio.io/istio/mixer/adapter"
@@ -44,6 +49,20 @@ import (
 const (
 	telemetryClient = "telemetry"
 	policyClient    = "policy"
+
+	// mixerGRPCPortName is the name of the container port that exposes Mixer's gRPC API, for both the
+	// telemetry and policy deployments.
+	mixerGRPCPortName = "grpc-mixer"
+
+	// mixerCtrlzPortName is the name of the container port that exposes Mixer's ctrlz introspection endpoint.
+	mixerCtrlzPortName = "ctrlz"
+
+	// mixerMonitoringPortName is the name of the container port that exposes Mixer's Prometheus metrics endpoint.
+	mixerMonitoringPortName = "http-monitoring"
+
+	configPropagationTimeout = time.Minute
+	configPollInitialDelay   = 50 * time.Millisecond
+	configPollMaxDelay       = 2 * time.Second
 )
 
 var (
@@ -107,15 +126,18 @@ func (c *localComponent) Init(ctx environment.ComponentContext, deps map[depende
 	client := istio_mixer_v1.NewMixerClient(conn)
 
 	return &deployedMixer{
-		local: true,
-		conn:  conn,
-		clients: map[string]istio_mixer_v1.MixerClient{
-			telemetryClient: client,
-			policyClient:    client,
-		},
+		local:   true,
 		args:    args,
-		server:  mi,
 		workdir: dir,
+		instances: map[environment.MixerKey]*mixerInstance{
+			{}: {
+				telemetryClient: client,
+				policyClient:    client,
+				conn:            conn,
+				server:          mi,
+				monitoringAddr:  mi.MonitoringAddr().String(),
+			},
+		},
 	}, nil
 }
 
@@ -142,26 +164,81 @@ func (c *kubeComponent) Init(ctx environment.ComponentContext, deps map[dependen
 	res := &deployedMixer{
 		local: false,
 		// Use the DefaultArgs to get config identity attribute
-		args: server.DefaultArgs(),
+		args:      server.DefaultArgs(),
+		instances: make(map[environment.MixerKey]*mixerInstance),
+	}
+
+	for _, cluster := range e.Clusters() {
+		revisions, err := cluster.Revisions()
+		if err != nil {
+			closeAll(res.instances)
+			return nil, err
+		}
+		if len(revisions) == 0 {
+			// No istio.io/rev labels found in this cluster; fall back to the legacy, unrevisioned deployment.
+			revisions = []string{""}
+		}
+
+		for _, rev := range revisions {
+			inst, err := newMixerInstance(cluster, rev)
+			if err != nil {
+				closeAll(res.instances)
+				return nil, err
+			}
+			res.instances[environment.MixerKey{Cluster: cluster.Name(), Revision: rev}] = inst
+		}
+	}
+
+	return res, nil
+}
+
+// closeAll tears down every already-constructed instance. It is used to unwind kubeComponent.Init when a later
+// cluster or revision fails to initialize, so that earlier forwarders/connections aren't leaked.
+func closeAll(instances map[environment.MixerKey]*mixerInstance) {
+	for _, inst := range instances {
+		closeInstance(inst)
+	}
+}
+
+// newMixerInstance discovers and connects to the telemetry and policy Mixer pods running in cluster under the
+// given istio revision. revision is empty for clusters that do not label their Mixer pods with istio.io/rev.
+func newMixerInstance(cluster kubernetes.ClusterAccessor, revision string) (*mixerInstance, error) {
+	inst := &mixerInstance{}
+	built := false
+	defer func() {
+		// Tear down any forwarders/connections opened so far if we're bailing out partway through.
+		if !built {
+			closeInstance(inst)
+		}
+	}()
+
+	selectors := []string{"istio=mixer"}
+	if revision != "" {
+		selectors = append(selectors, "istio.io/rev="+revision)
 	}
 
+	var ctrlzPod *kubeApiCore.Pod
 	for _, clientType := range []string{telemetryClient, policyClient} {
-		pod, err := e.Accessor.WaitForPodBySelectors("istio-system", "istio=mixer", "istio-mixer-type="+clientType)
+		podSelectors := append(append([]string{}, selectors...), "istio-mixer-type="+clientType)
+		pod, err := cluster.Accessor().WaitForPodBySelectors("istio-system", podSelectors...)
+		if err != nil {
+			return nil, err
+		}
+
+		grpcPort, err := findContainerPort(pod, mixerGRPCPortName)
 		if err != nil {
 			return nil, err
 		}
 
-		// TODO: Right now, simply connect to the telemetry backend at port 9092. We can expand this to connect
-		// to policy backend and dynamically figure out ports later.
-		// See https://github.com/istio/istio/issues/6175
 		options := &kube.PodSelectOptions{
 			PodNamespace: pod.Namespace,
 			PodName:      pod.Name,
 		}
-		forwarder, err := kube.PortForward(e.KubeSettings().KubeConfig, options, "", strconv.Itoa(9092))
+		forwarder, err := kube.PortForward(cluster.KubeConfig(), options, "", strconv.Itoa(int(grpcPort)))
 		if err != nil {
 			return nil, err
 		}
+		inst.forwarders = append(inst.forwarders, forwarder)
 
 		conn, err := grpc.Dial(forwarder.Address(), grpc.WithInsecure())
 		if err != nil {
@@ -169,80 +246,385 @@ func (c *kubeComponent) Init(ctx environment.ComponentContext, deps map[dependen
 		}
 
 		client := istio_mixer_v1.NewMixerClient(conn)
-		res.clients[clientType] = client
-		res.forwarders = append(res.forwarders, forwarder)
+		if clientType == telemetryClient {
+			inst.telemetryClient = client
+			ctrlzPod = pod
+		} else {
+			inst.policyClient = client
+		}
 	}
 
-	return res, nil
+	ctrlzPort, err := findContainerPort(ctrlzPod, mixerCtrlzPortName)
+	if err != nil {
+		return nil, err
+	}
+
+	ctrlzOptions := &kube.PodSelectOptions{
+		PodNamespace: ctrlzPod.Namespace,
+		PodName:      ctrlzPod.Name,
+	}
+	inst.ctrlzForwarder, err = kube.PortForward(cluster.KubeConfig(), ctrlzOptions, "", strconv.Itoa(int(ctrlzPort)))
+	if err != nil {
+		return nil, err
+	}
+
+	monitoringPort, err := findContainerPort(ctrlzPod, mixerMonitoringPortName)
+	if err != nil {
+		return nil, err
+	}
+
+	monitoringOptions := &kube.PodSelectOptions{
+		PodNamespace: ctrlzPod.Namespace,
+		PodName:      ctrlzPod.Name,
+	}
+	inst.monitoringForwarder, err = kube.PortForward(cluster.KubeConfig(), monitoringOptions, "", strconv.Itoa(int(monitoringPort)))
+	if err != nil {
+		return nil, err
+	}
+
+	built = true
+	return inst, nil
+}
+
+// findContainerPort returns the container port named portName on pod, so that callers can port-forward to it
+// without hardcoding a port number that may differ between Mixer deployments.
+func findContainerPort(pod *kubeApiCore.Pod, portName string) (int32, error) {
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == portName {
+				return p.ContainerPort, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("could not find port %q on pod %s/%s", portName, pod.Namespace, pod.Name)
 }
 
+// mixerInstance holds everything needed to talk to a single running Mixer instance.
+type mixerInstance struct {
+	telemetryClient istio_mixer_v1.MixerClient
+	policyClient    istio_mixer_v1.MixerClient
+
+	// conn and server are only set when this instance is running in-process (local environment).
+	conn   *grpc.ClientConn
+	server *server.Server
+
+	forwarders          []kube.PortForwarder
+	ctrlzForwarder      kube.PortForwarder
+	monitoringForwarder kube.PortForwarder
+
+	// monitoringAddr is the in-process Mixer's actual bound monitoring address, read back from the server
+	// once it starts listening so that Metrics can reach it. Only set in the local environment.
+	monitoringAddr string
+}
+
+// MixerOption, CheckQuota, CheckResponse and MetricsClient live on environment.DeployedMixer, the interface
+// this component's callers are actually handed; these aliases let existing mixer.* call sites keep working.
+type (
+	MixerOption   = environment.MixerOption
+	CheckQuota    = environment.CheckQuota
+	CheckResponse = environment.CheckResponse
+	MetricsClient = environment.MetricsClient
+)
+
+// WithCluster targets the Mixer instance running in the named cluster.
+var WithCluster = environment.WithCluster
+
+// WithRevision targets the Mixer instance running under the named istio revision.
+var WithRevision = environment.WithRevision
+
 type deployedMixer struct {
 	// Indicates that the component is running in local mode.
 	local bool
 
-	conn    *grpc.ClientConn
-	clients map[string]istio_mixer_v1.MixerClient
+	instances map[environment.MixerKey]*mixerInstance
 
 	args    *server.Args
-	server  *server.Server
 	workdir string
 
-	forwarders []kube.PortForwarder
+	// configApplier, if set, applies Mixer CRD configuration to the Kubernetes environment. It is required
+	// for ApplyConfig to work when running against a kube-deployed Mixer.
+	configApplier func(cfg string) error
+}
+
+// instance resolves opts to the Mixer instance that should handle the call. When no options are given and
+// exactly one instance is known, that instance is used regardless of the cluster/revision it was keyed under —
+// this keeps pre-existing single-instance callers (the common case) working without requiring them to name a
+// cluster or revision that is otherwise only meaningful to multi-cluster/multi-revision tests.
+func (d *deployedMixer) instance(opts []MixerOption) (*mixerInstance, error) {
+	if len(opts) == 0 && len(d.instances) == 1 {
+		for _, inst := range d.instances {
+			return inst, nil
+		}
+	}
+
+	var key environment.MixerKey
+	for _, opt := range opts {
+		opt(&key)
+	}
+
+	inst, ok := d.instances[key]
+	if !ok {
+		return nil, fmt.Errorf("no Mixer instance found for cluster=%q revision=%q", key.Cluster, key.Revision)
+	}
+	return inst, nil
 }
 
 // Report implements DeployedMixer.Report.
-func (d *deployedMixer) Report(t testing.TB, attributes map[string]interface{}) {
+func (d *deployedMixer) Report(t testing.TB, attributes map[string]interface{}, opts ...MixerOption) {
 	t.Helper()
 
+	inst, err := d.instance(opts)
+	if err != nil {
+		t.Fatalf("Error selecting Mixer instance: %v", err)
+	}
+
 	req := istio_mixer_v1.ReportRequest{
 		Attributes: []istio_mixer_v1.CompressedAttributes{
 			getAttrBag(attributes)},
 	}
-	_, err := d.clients[telemetryClient].Report(context.Background(), &req)
+	if _, err := inst.telemetryClient.Report(context.Background(), &req); err != nil {
+		t.Fatalf("Error sending report: %v", err)
+	}
+}
+
+// Check implements DeployedMixer.Check.
+func (d *deployedMixer) Check(
+	t testing.TB, attributes map[string]interface{}, quotas []CheckQuota, opts ...MixerOption) (*CheckResponse, error) {
+	t.Helper()
 
+	inst, err := d.instance(opts)
 	if err != nil {
-		t.Fatalf("Error sending report: %v", err)
+		return nil, err
+	}
+
+	req := istio_mixer_v1.CheckRequest{
+		Attributes: getAttrBag(attributes),
+	}
+
+	if len(quotas) > 0 {
+		req.Quotas = make(map[string]istio_mixer_v1.CheckRequest_QuotaParams, len(quotas))
+		for _, q := range quotas {
+			req.Quotas[q.Name] = istio_mixer_v1.CheckRequest_QuotaParams{Amount: q.Amount}
+		}
+	}
+
+	resp, err := inst.policyClient.Check(context.Background(), &req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CheckResponse{Raw: resp}, nil
+}
+
+// AssertCheckSucceeded performs a Check call and fails the test if it was not allowed.
+func (d *deployedMixer) AssertCheckSucceeded(
+	t testing.TB, attributes map[string]interface{}, quotas []CheckQuota, opts ...MixerOption) *CheckResponse {
+	t.Helper()
+
+	resp, err := d.Check(t, attributes, quotas, opts...)
+	if err != nil {
+		t.Fatalf("Error sending check: %v", err)
+	}
+	if !resp.Succeeded() {
+		t.Fatalf("Expected check to succeed, got status: %v", resp.Status())
+	}
+	return resp
+}
+
+// AssertCheckDenied performs a Check call and fails the test if it was allowed.
+func (d *deployedMixer) AssertCheckDenied(
+	t testing.TB, attributes map[string]interface{}, quotas []CheckQuota, opts ...MixerOption) *CheckResponse {
+	t.Helper()
+
+	resp, err := d.Check(t, attributes, quotas, opts...)
+	if err != nil {
+		t.Fatalf("Error sending check: %v", err)
+	}
+	if resp.Succeeded() {
+		t.Fatalf("Expected check to be denied, got status: %v", resp.Status())
 	}
+	return resp
+}
+
+// WaitForCheckStatus repeatedly performs Check calls with the given attributes until the response status code
+// matches want, or timeout elapses.
+func (d *deployedMixer) WaitForCheckStatus(
+	t testing.TB, attributes map[string]interface{}, want int32, timeout time.Duration, opts ...MixerOption) *CheckResponse {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	var last *CheckResponse
+	for time.Now().Before(deadline) {
+		resp, err := d.Check(t, attributes, nil, opts...)
+		if err == nil {
+			last = resp
+			if resp.Status().Code == want {
+				return resp
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatalf("Timed out waiting for check status %d, last response: %v", want, last)
+	return nil
+}
+
+// Metrics returns a client for querying metrics that the selected Mixer instance has reported, for use in
+// adapter integration tests that need to verify a Report actually flowed through to the intended handler. In the
+// local environment the client points at the in-process server's monitoring endpoint, which serves no /metrics,
+// so its queries return an error.
+func (d *deployedMixer) Metrics(opts ...MixerOption) (*MetricsClient, error) {
+	inst, err := d.instance(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.local {
+		return &MetricsClient{Address: inst.monitoringAddr}, nil
+	}
+
+	return &MetricsClient{Address: inst.monitoringForwarder.Address()}, nil
 }
 
 // ApplyConfig implements Configurable.ApplyConfig.
 func (d *deployedMixer) ApplyConfig(cfg string) error {
-	// This only applies when Mixer is running locally.
+	revision := configRevision(cfg)
+
 	if d.local {
 		file := path.Join(d.workdir, "config.yaml")
-		err := ioutil.WriteFile(file, []byte(cfg), os.ModePerm)
-
-		if err == nil {
-			// TODO: Implement a mechanism for reliably waiting for the configuration to disseminate in the system.
-			// We can use CtrlZ to expose the config state of Mixer.
-			// See https://github.com/istio/istio/issues/6169 and https://github.com/istio/istio/issues/6170.
-			time.Sleep(time.Second * 3)
+		if err := ioutil.WriteFile(file, []byte(cfg), os.ModePerm); err != nil {
+			return err
 		}
 
+		return d.waitForConfigRevision(revision, configPropagationTimeout)
+	}
+
+	if d.configApplier == nil {
+		return fmt.Errorf("no config applier configured for Mixer component in the Kubernetes environment")
+	}
+
+	if err := d.configApplier(cfg); err != nil {
 		return err
 	}
 
-	// We shouldn't getting an ApplyConfig for the Kubernetes case.
-	return fmt.Errorf("unexpected ApplyConfig call to Mixer component for Kubernetes environment: %s", cfg)
+	return d.waitForConfigRevision(revision, configPropagationTimeout)
+}
+
+// SetConfigApplier configures the function used to push Mixer CRD configuration to the Kubernetes environment.
+// It must be called before ApplyConfig when running against a kube-deployed Mixer.
+func (d *deployedMixer) SetConfigApplier(applier func(cfg string) error) {
+	d.configApplier = applier
+}
+
+// configRevision computes a stable identifier for cfg, so that waitForConfigRevision can tell once a freshly
+// applied configuration has actually been loaded by the running Mixer instance.
+func configRevision(cfg string) string {
+	sum := sha256.Sum256([]byte(cfg))
+	return hex.EncodeToString(sum[:])
+}
+
+// waitForConfigRevision polls every Mixer instance's loaded config revision (see configRevisionFunc) until each
+// matches revision, or returns an error once timeout elapses.
+func (d *deployedMixer) waitForConfigRevision(revision string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for key, inst := range d.instances {
+		getRevision, err := d.configRevisionFunc(inst)
+		if err != nil {
+			return err
+		}
+
+		delay := configPollInitialDelay
+		for {
+			current, err := getRevision()
+			if err == nil && current == revision {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				return fmt.Errorf("timed out waiting for Mixer (cluster=%q revision=%q) to load config revision %s",
+					key.Cluster, key.Revision, revision)
+			}
+
+			time.Sleep(delay)
+			delay *= 2
+			if delay > configPollMaxDelay {
+				delay = configPollMaxDelay
+			}
+		}
+	}
+
+	return nil
+}
+
+// configRevisionFunc returns a function that fetches the config revision currently loaded by inst: in-process
+// via Server.ConfigRevision in the local environment, or over the port-forwarded ctrlz endpoint in Kubernetes.
+func (d *deployedMixer) configRevisionFunc(inst *mixerInstance) (func() (string, error), error) {
+	if d.local {
+		return inst.server.ConfigRevision, nil
+	}
+
+	if inst.ctrlzForwarder == nil {
+		return nil, fmt.Errorf("ctrlz port-forward is not available for this Mixer instance")
+	}
+
+	addr := inst.ctrlzForwarder.Address()
+	return func() (string, error) {
+		resp, err := http.Get(fmt.Sprintf("http://%s/debug/configState?output=json", addr))
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		var state struct {
+			Revision string `json:"revision"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+			return "", err
+		}
+		return state.Revision, nil
+	}, nil
 }
 
 // Close implements io.Closer.
 func (d *deployedMixer) Close() error {
 	var err error
-	if d.conn != nil {
-		err = multierr.Append(err, d.conn.Close())
-		d.conn = nil
+
+	for _, inst := range d.instances {
+		err = multierr.Append(err, closeInstance(inst))
 	}
 
-	if d.server != nil {
-		err = multierr.Append(err, d.server.Close())
-		d.server = nil
+	d.instances = nil
+
+	return err
+}
+
+// closeInstance tears down every forwarder/connection that inst has opened, collecting any errors. It is safe
+// to call on a partially constructed instance, where some fields may still be nil.
+func closeInstance(inst *mixerInstance) error {
+	var err error
+
+	if inst.conn != nil {
+		err = multierr.Append(err, inst.conn.Close())
+	}
+
+	if inst.server != nil {
+		err = multierr.Append(err, inst.server.Close())
 	}
 
-	for _, fw := range d.forwarders {
+	for _, fw := range inst.forwarders {
 		fw.Close()
 	}
 
+	if inst.ctrlzForwarder != nil {
+		inst.ctrlzForwarder.Close()
+	}
+
+	if inst.monitoringForwarder != nil {
+		inst.monitoringForwarder.Close()
+	}
+
 	return err
 }
 
@@ -0,0 +1,34 @@
+//  Copyright 2018 Istio Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package environment declares the contract between the test framework and the environments (local process,
+// Kubernetes cluster, ...) that components can be deployed into, along with the interfaces components expose
+// for environment-agnostic test code to drive them.
+package environment
+
+// EnvironmentID identifies a kind of test environment.
+type EnvironmentID string
+
+// Environment represents a concrete environment that components can be deployed into.
+type Environment interface {
+	// EnvironmentID returns the identifier for this environment.
+	EnvironmentID() EnvironmentID
+}
+
+// ComponentContext is handed to a Component's Init method so that it can discover which Environment it is
+// running against.
+type ComponentContext interface {
+	// Environment returns the Environment components are being initialized against.
+	Environment() Environment
+}
@@ -0,0 +1,193 @@
+//  Copyright 2018 Istio Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package environment
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	istio_mixer_v1 "istio.io/api/mixer/v1"
+	rpc "istio.io/gogo-genproto/googleapis/rpc/status"
+)
+
+// DeployedMixer is the interface for interacting with a deployed Mixer instance, independent of which
+// environment (local process, Kubernetes cluster, ...) it was deployed into.
+type DeployedMixer interface {
+	// Report sends the given attributes to Mixer's telemetry API, failing the test on error.
+	Report(t testing.TB, attributes map[string]interface{}, opts ...MixerOption)
+
+	// Check sends the given attributes and quotas to Mixer's policy API.
+	Check(t testing.TB, attributes map[string]interface{}, quotas []CheckQuota, opts ...MixerOption) (*CheckResponse, error)
+
+	// AssertCheckSucceeded performs a Check call and fails the test if it was not allowed.
+	AssertCheckSucceeded(t testing.TB, attributes map[string]interface{}, quotas []CheckQuota, opts ...MixerOption) *CheckResponse
+
+	// AssertCheckDenied performs a Check call and fails the test if it was allowed.
+	AssertCheckDenied(t testing.TB, attributes map[string]interface{}, quotas []CheckQuota, opts ...MixerOption) *CheckResponse
+
+	// WaitForCheckStatus repeatedly performs Check calls until the response status code matches want, or
+	// timeout elapses.
+	WaitForCheckStatus(t testing.TB, attributes map[string]interface{}, want int32, timeout time.Duration, opts ...MixerOption) *CheckResponse
+
+	// Metrics returns a client for querying metrics that the selected Mixer instance has reported.
+	Metrics(opts ...MixerOption) (*MetricsClient, error)
+}
+
+// MixerKey identifies a single Mixer instance by the cluster and istio revision it is running under. The zero
+// value selects the only instance present in non-multicluster, non-revisioned environments.
+type MixerKey struct {
+	Cluster  string
+	Revision string
+}
+
+// MixerOption selects which Mixer instance a Report/Check/Metrics call should target, for environments running
+// multiple Mixer instances across clusters or istio revisions.
+type MixerOption func(*MixerKey)
+
+// WithCluster targets the Mixer instance running in the named cluster.
+func WithCluster(name string) MixerOption {
+	return func(k *MixerKey) {
+		k.Cluster = name
+	}
+}
+
+// WithRevision targets the Mixer instance running under the named istio revision.
+func WithRevision(rev string) MixerOption {
+	return func(k *MixerKey) {
+		k.Revision = rev
+	}
+}
+
+// CheckQuota names a quota that should be requested as part of a Check call, and the amount to request.
+type CheckQuota struct {
+	Name   string
+	Amount int64
+}
+
+// CheckResponse decodes the result of a Check call for easy assertions in adapter integration tests.
+type CheckResponse struct {
+	Raw *istio_mixer_v1.CheckResponse
+}
+
+// Status returns the precondition status returned by Mixer.
+func (c *CheckResponse) Status() rpc.Status {
+	return c.Raw.Precondition.Status
+}
+
+// Succeeded returns true if the precondition check was allowed.
+func (c *CheckResponse) Succeeded() bool {
+	return c.Status().Code == 0
+}
+
+// ValidUseCount returns the number of times this result may be used before Mixer must be consulted again.
+func (c *CheckResponse) ValidUseCount() int32 {
+	return c.Raw.Precondition.ValidUseCount
+}
+
+// ReferencedAttributes returns the attributes that were referenced while evaluating the check.
+func (c *CheckResponse) ReferencedAttributes() istio_mixer_v1.ReferencedAttributes {
+	return c.Raw.Precondition.ReferencedAttributes
+}
+
+// Quota returns the amount granted for the named quota, and whether that quota was present in the response.
+func (c *CheckResponse) Quota(name string) (int64, bool) {
+	q, ok := c.Raw.Quotas[name]
+	if !ok {
+		return 0, false
+	}
+	return q.GrantedAmount, true
+}
+
+// MetricsClient scrapes a Mixer instance's monitoring endpoint and exposes typed queries over the result.
+type MetricsClient struct {
+	Address string
+}
+
+// CounterValue returns the current value of the named counter metric carrying the given labels.
+func (m *MetricsClient) CounterValue(name string, labels map[string]string) (float64, error) {
+	families, err := m.scrape()
+	if err != nil {
+		return 0, err
+	}
+
+	family, ok := families[name]
+	if !ok {
+		return 0, fmt.Errorf("metric %q not found", name)
+	}
+
+	for _, metric := range family.Metric {
+		if labelsMatch(metric.Label, labels) {
+			return metric.Counter.GetValue(), nil
+		}
+	}
+
+	return 0, fmt.Errorf("metric %q with labels %v not found", name, labels)
+}
+
+// WaitForCounter polls CounterValue until its value is at least atLeast, or returns an error once timeout elapses.
+func (m *MetricsClient) WaitForCounter(name string, labels map[string]string, atLeast float64, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var last float64
+	for {
+		v, err := m.CounterValue(name, labels)
+		if err == nil {
+			last = v
+			if v >= atLeast {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for counter %q with labels %v to reach %v, last value: %v",
+				name, labels, atLeast, last)
+		}
+
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func (m *MetricsClient) scrape() (map[string]*dto.MetricFamily, error) {
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", m.Address))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(resp.Body)
+}
+
+func labelsMatch(have []*dto.LabelPair, want map[string]string) bool {
+	if len(want) == 0 {
+		return true
+	}
+
+	values := make(map[string]string, len(have))
+	for _, p := range have {
+		values[p.GetName()] = p.GetValue()
+	}
+
+	for k, v := range want {
+		if values[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+//  Copyright 2018 Istio Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package kubernetes
+
+import "istio.io/istio/pkg/test/kube"
+
+// ClusterAccessor exposes everything needed to reach Istio components running in a single cluster that is
+// part of a (possibly multi-cluster) Kubernetes test environment.
+type ClusterAccessor interface {
+	// Name is the name this cluster is known by within the test environment. It is empty for the default
+	// cluster of a single-cluster environment.
+	Name() string
+
+	// KubeConfig is the path to the kubeconfig file used to reach this cluster.
+	KubeConfig() string
+
+	// Accessor is the generic Kubernetes accessor for this cluster.
+	Accessor() *kube.Accessor
+
+	// Revisions lists the istio.io/rev label values found on Mixer pods in this cluster. It is empty for
+	// clusters running an unrevisioned (single Mixer per cluster) install.
+	Revisions() ([]string, error)
+}
+
+// Clusters returns every cluster known to this Kubernetes environment. If none were explicitly configured via
+// SetClusters (the common, single-cluster case), a single ClusterAccessor wrapping this environment's own
+// Accessor and KubeConfig is returned instead, so that existing single-cluster callers keep working unchanged.
+func (e *Implementation) Clusters() []ClusterAccessor {
+	if len(e.clusters) > 0 {
+		return e.clusters
+	}
+
+	return []ClusterAccessor{
+		&clusterAccessor{
+			name:       "",
+			kubeConfig: e.KubeSettings().KubeConfig,
+			accessor:   e.Accessor,
+		},
+	}
+}
+
+// clusterAccessor is the default ClusterAccessor implementation, wrapping a plain kube.Accessor and kubeconfig
+// path.
+type clusterAccessor struct {
+	name       string
+	kubeConfig string
+	accessor   *kube.Accessor
+}
+
+// NewClusterAccessor creates a ClusterAccessor for a single named cluster, for use with SetClusters.
+func NewClusterAccessor(name, kubeConfig string, accessor *kube.Accessor) ClusterAccessor {
+	return &clusterAccessor{name: name, kubeConfig: kubeConfig, accessor: accessor}
+}
+
+func (c *clusterAccessor) Name() string            { return c.name }
+func (c *clusterAccessor) KubeConfig() string       { return c.kubeConfig }
+func (c *clusterAccessor) Accessor() *kube.Accessor { return c.accessor }
+
+// Revisions lists the distinct istio.io/rev label values carried by Mixer pods in this cluster.
+func (c *clusterAccessor) Revisions() ([]string, error) {
+	pods, err := c.accessor.ListPodsBySelectors("istio-system", "istio=mixer")
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []string
+	seen := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		rev, ok := pod.Labels["istio.io/rev"]
+		if !ok || seen[rev] {
+			continue
+		}
+		seen[rev] = true
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, nil
+}
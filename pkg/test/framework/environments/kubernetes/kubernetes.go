@@ -0,0 +1,67 @@
+//  Copyright 2018 Istio Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package kubernetes implements the Kubernetes test Environment: components are initialized against a live
+// Istio installation running in one or more Kubernetes clusters.
+package kubernetes
+
+import (
+	"istio.io/istio/pkg/test/framework/environment"
+	"istio.io/istio/pkg/test/kube"
+)
+
+// Settings holds the Kubernetes-specific configuration for an Implementation.
+type Settings struct {
+	// KubeConfig is the path to the kubeconfig file used to reach the primary cluster.
+	KubeConfig string
+}
+
+// Implementation is the Kubernetes test Environment.
+type Implementation struct {
+	// Accessor is the generic Kubernetes accessor for the primary cluster.
+	Accessor *kube.Accessor
+
+	settings *Settings
+
+	// clusters, when non-empty, overrides the default single-cluster view returned by Clusters with an
+	// explicit set of clusters for multi-cluster test environments.
+	clusters []ClusterAccessor
+}
+
+// NewImplementation creates a Kubernetes Environment talking to the primary cluster described by settings and
+// accessor. Multi-cluster environments should follow up with SetClusters.
+func NewImplementation(settings *Settings, accessor *kube.Accessor) *Implementation {
+	return &Implementation{
+		Accessor: accessor,
+		settings: settings,
+	}
+}
+
+// EnvironmentID implements environment.Environment.
+func (e *Implementation) EnvironmentID() environment.EnvironmentID {
+	return "kubernetes"
+}
+
+// KubeSettings returns the Kubernetes-specific settings for this environment.
+func (e *Implementation) KubeSettings() *Settings {
+	return e.settings
+}
+
+// SetClusters configures the explicit set of clusters a multi-cluster test environment is running across.
+// Environments that don't call this are treated as single-cluster, per Clusters.
+func (e *Implementation) SetClusters(clusters []ClusterAccessor) {
+	e.clusters = clusters
+}
+
+var _ environment.Environment = &Implementation{}